@@ -0,0 +1,220 @@
+package pmapi
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// tofuPinStoreFile is the name of the file, relative to the verifier's config
+// dir, in which learned TOFU pins are persisted.
+const tofuPinStoreFile = "tofu_pins.json"
+
+// PinVerifier checks a server's public key against TrustedAPIPins and,
+// optionally, against pins learned via Trust-On-First-Use for hosts that
+// aren't covered by the built-in list (self-hosted or on-prem Proton
+// instances, or emergency proxy servers added after this binary was built).
+//
+// TOFU is opt-in: with useTOFU false, a host that doesn't match
+// TrustedAPIPins is still rejected, preserving today's strict-pinning
+// behaviour.
+type PinVerifier struct {
+	locker sync.Mutex
+
+	storePath string
+	useTOFU   bool
+	pins      map[string][]string // host -> known SPKI SHA-256 pins
+	reporter  *TLSReporter
+}
+
+// NewPinVerifier creates a PinVerifier which persists learned pins under
+// configDir. TOFU learning is only performed once useTOFU is true. reporter
+// may be nil, in which case TOFU pin mismatches are not reported anywhere;
+// when set, a changed TOFU pin is reported through it exactly like a
+// built-in pin mismatch.
+func NewPinVerifier(configDir string, useTOFU bool, reporter *TLSReporter) (*PinVerifier, error) {
+	verifier := &PinVerifier{
+		storePath: filepath.Join(configDir, tofuPinStoreFile),
+		useTOFU:   useTOFU,
+		pins:      make(map[string][]string),
+		reporter:  reporter,
+	}
+
+	if err := verifier.load(); err != nil {
+		return nil, err
+	}
+
+	return verifier, nil
+}
+
+// VerifyHostPin checks the leaf certificate's SPKI pin from state against
+// TrustedAPIPins and, if no built-in pin matches and TOFU is enabled, against
+// pins previously learned for host. On first contact with a new host under
+// TOFU, the observed pin is learned and verification succeeds. If a host's
+// pin doesn't match, a TLSReport carrying the negotiated TLS parameters from
+// state is sent through the verifier's reporter, the same for a built-in
+// pin mismatch as for a changed TOFU pin. ErrTLSMismatch is returned if
+// verification fails.
+func (v *PinVerifier) VerifyHostPin(host, port string, state tls.ConnectionState, appVersion string) error {
+	if len(state.PeerCertificates) == 0 {
+		return ErrTLSMismatch
+	}
+
+	cert := state.PeerCertificates[0]
+	pin := spkiPin(cert)
+
+	for _, trusted := range TrustedAPIPins {
+		if trusted == pin {
+			return nil
+		}
+	}
+
+	if !v.useTOFU {
+		v.reportMismatch(host, port, state, nil, appVersion)
+		return ErrTLSMismatch
+	}
+
+	v.locker.Lock()
+	defer v.locker.Unlock()
+
+	known, ok := v.pins[host]
+	if !ok {
+		v.pins[host] = []string{pin}
+		return v.save()
+	}
+
+	for _, k := range known {
+		if k == pin {
+			return nil
+		}
+	}
+
+	v.reportMismatch(host, port, state, known, appVersion)
+
+	return ErrTLSMismatch
+}
+
+// reportMismatch sends a TLSReport for a pin that didn't match knownPins
+// (the host's previously learned TOFU pins, or nil when TOFU is disabled)
+// through the existing TLSReporter pathway, if one was configured. The
+// report's negotiated-version/negotiated-cipher/negotiated-alpn fields are
+// filled in from state so pin-failure telemetry is diagnosable even when
+// the failure turns out to be TLS-configuration related.
+func (v *PinVerifier) reportMismatch(host, port string, state tls.ConnectionState, knownPins []string, appVersion string) {
+	if v.reporter == nil {
+		return
+	}
+
+	certChain := make([]string, len(state.PeerCertificates))
+	for i, cert := range state.PeerCertificates {
+		certChain[i] = pemEncodeCert(cert)
+	}
+
+	report := NewTLSReport(host, port, host, certChain, knownPins, appVersion)
+	negotiated(&report, state)
+
+	v.reporter.Report(report)
+}
+
+// AddTrustedPin records pin as trusted for host, so users can bootstrap a
+// pin for a self-hosted instance or rotate a proxy's pin from the settings
+// UI without waiting for a new bridge release.
+func (v *PinVerifier) AddTrustedPin(host, pin string) error {
+	v.locker.Lock()
+	defer v.locker.Unlock()
+
+	for _, k := range v.pins[host] {
+		if k == pin {
+			return nil
+		}
+	}
+
+	v.pins[host] = append(v.pins[host], pin)
+
+	return v.save()
+}
+
+// RemoveTrustedPin removes a previously learned or added pin for host.
+func (v *PinVerifier) RemoveTrustedPin(host, pin string) error {
+	v.locker.Lock()
+	defer v.locker.Unlock()
+
+	known, ok := v.pins[host]
+	if !ok {
+		return nil
+	}
+
+	filtered := known[:0]
+	for _, k := range known {
+		if k != pin {
+			filtered = append(filtered, k)
+		}
+	}
+
+	if len(filtered) == 0 {
+		delete(v.pins, host)
+	} else {
+		v.pins[host] = filtered
+	}
+
+	return v.save()
+}
+
+// ListTrustedPins returns the pins learned via TOFU or added with
+// AddTrustedPin for host, if any.
+func (v *PinVerifier) ListTrustedPins(host string) []string {
+	v.locker.Lock()
+	defer v.locker.Unlock()
+
+	return append([]string(nil), v.pins[host]...)
+}
+
+func (v *PinVerifier) load() error {
+	b, err := ioutil.ReadFile(v.storePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to read TOFU pin store")
+	}
+
+	if err := json.Unmarshal(b, &v.pins); err != nil {
+		return errors.Wrap(err, "failed to unmarshal TOFU pin store")
+	}
+
+	return nil
+}
+
+// save persists the pin store; the caller must hold v.locker.
+func (v *PinVerifier) save() error {
+	b, err := json.Marshal(v.pins)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal TOFU pin store")
+	}
+
+	if err := ioutil.WriteFile(v.storePath, b, 0600); err != nil {
+		return errors.Wrap(err, "failed to write TOFU pin store")
+	}
+
+	return nil
+}
+
+// spkiPin returns the pin-sha256 value for cert's subject public key info,
+// formatted identically to the entries in TrustedAPIPins.
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return `pin-sha256="` + base64.StdEncoding.EncodeToString(sum[:]) + `"`
+}
+
+// pemEncodeCert renders cert as a PEM block for TLSReport.ServedCertificateChain.
+func pemEncodeCert(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
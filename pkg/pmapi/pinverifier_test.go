@@ -0,0 +1,141 @@
+package pmapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func generateTestCert(t *testing.T) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() error = %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate() error = %v", err)
+	}
+
+	return cert
+}
+
+func connStateFor(certs ...*x509.Certificate) tls.ConnectionState {
+	return tls.ConnectionState{
+		Version:            tls.VersionTLS13,
+		CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+		NegotiatedProtocol: "h2",
+		PeerCertificates:   certs,
+	}
+}
+
+func TestPinVerifierTOFULearnsOnFirstUse(t *testing.T) {
+	verifier, err := NewPinVerifier(t.TempDir(), true, nil)
+	if err != nil {
+		t.Fatalf("NewPinVerifier() error = %v", err)
+	}
+
+	cert := generateTestCert(t)
+
+	if err := verifier.VerifyHostPin("self-hosted.example.com", "443", connStateFor(cert), "bridge_1.0.0"); err != nil {
+		t.Fatalf("VerifyHostPin() on first use error = %v, want nil", err)
+	}
+
+	pins := verifier.ListTrustedPins("self-hosted.example.com")
+	if len(pins) != 1 {
+		t.Fatalf("len(ListTrustedPins()) = %d, want 1 after TOFU learn", len(pins))
+	}
+
+	// Reconnecting with the same cert must succeed without relearning.
+	if err := verifier.VerifyHostPin("self-hosted.example.com", "443", connStateFor(cert), "bridge_1.0.0"); err != nil {
+		t.Fatalf("VerifyHostPin() on second use error = %v, want nil", err)
+	}
+}
+
+func TestPinVerifierTOFUMismatchIsReportedAndRejected(t *testing.T) {
+	reporter, err := NewTLSReporter(t.TempDir(), NewClientConfig("bridge_1.0.0", "test-agent"))
+	if err != nil {
+		t.Fatalf("NewTLSReporter() error = %v", err)
+	}
+	defer reporter.Close()
+
+	verifier, err := NewPinVerifier(t.TempDir(), true, reporter)
+	if err != nil {
+		t.Fatalf("NewPinVerifier() error = %v", err)
+	}
+
+	firstCert := generateTestCert(t)
+	if err := verifier.VerifyHostPin("self-hosted.example.com", "443", connStateFor(firstCert), "bridge_1.0.0"); err != nil {
+		t.Fatalf("VerifyHostPin() on first use error = %v, want nil", err)
+	}
+
+	rotatedCert := generateTestCert(t)
+	if err := verifier.VerifyHostPin("self-hosted.example.com", "443", connStateFor(rotatedCert), "bridge_1.0.0"); err != ErrTLSMismatch {
+		t.Fatalf("VerifyHostPin() after pin rotation error = %v, want ErrTLSMismatch", err)
+	}
+
+	waitForPendingCount(t, reporter, 1, time.Second)
+}
+
+func TestPinVerifierWithoutTOFURejectsUnknownHostAndReports(t *testing.T) {
+	reporter, err := NewTLSReporter(t.TempDir(), NewClientConfig("bridge_1.0.0", "test-agent"))
+	if err != nil {
+		t.Fatalf("NewTLSReporter() error = %v", err)
+	}
+	defer reporter.Close()
+
+	verifier, err := NewPinVerifier(t.TempDir(), false, reporter)
+	if err != nil {
+		t.Fatalf("NewPinVerifier() error = %v", err)
+	}
+
+	cert := generateTestCert(t)
+	if err := verifier.VerifyHostPin("self-hosted.example.com", "443", connStateFor(cert), "bridge_1.0.0"); err != ErrTLSMismatch {
+		t.Fatalf("VerifyHostPin() with TOFU disabled error = %v, want ErrTLSMismatch", err)
+	}
+
+	waitForPendingCount(t, reporter, 1, time.Second)
+}
+
+func TestPinVerifierAddRemoveTrustedPin(t *testing.T) {
+	verifier, err := NewPinVerifier(t.TempDir(), true, nil)
+	if err != nil {
+		t.Fatalf("NewPinVerifier() error = %v", err)
+	}
+
+	if err := verifier.AddTrustedPin("proxy.example.com", `pin-sha256="AAAA"`); err != nil {
+		t.Fatalf("AddTrustedPin() error = %v", err)
+	}
+
+	if pins := verifier.ListTrustedPins("proxy.example.com"); len(pins) != 1 {
+		t.Fatalf("len(ListTrustedPins()) after AddTrustedPin = %d, want 1", len(pins))
+	}
+
+	if err := verifier.RemoveTrustedPin("proxy.example.com", `pin-sha256="AAAA"`); err != nil {
+		t.Fatalf("RemoveTrustedPin() error = %v", err)
+	}
+
+	if pins := verifier.ListTrustedPins("proxy.example.com"); len(pins) != 0 {
+		t.Fatalf("len(ListTrustedPins()) after RemoveTrustedPin = %d, want 0", len(pins))
+	}
+}
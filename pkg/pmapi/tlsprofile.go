@@ -0,0 +1,169 @@
+package pmapi
+
+import (
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+)
+
+// TLSProfile configures the TLS parameters used by the pmapi client for its
+// connections to the API, letting callers (and the bridge settings screen)
+// force TLS 1.3-only, restrict to AEAD cipher suites, or negotiate h2/http1.1
+// explicitly instead of relying on whatever the Go default tls.Config gives
+// us.
+//
+// Currently only TLSReporter's own report-delivery *http.Client is built
+// from it (via ClientConfig, in NewTLSReporter). The actual API connections
+// whose handshake PinVerifier.VerifyHostPin checks are established by an
+// external caller and handed in as a tls.ConnectionState; this profile is
+// not yet applied to that connection, so forcing TLS 1.3-only or trimming
+// cipher suites here does not constrain what PinVerifier ends up validating.
+type TLSProfile struct {
+	// MinVersion is the minimum TLS version the client will negotiate,
+	// e.g. tls.VersionTLS12.
+	MinVersion uint16
+
+	// MaxVersion is the maximum TLS version the client will negotiate. Zero
+	// means no cap beyond what the Go runtime supports.
+	MaxVersion uint16
+
+	// CipherSuites restricts negotiation to this list for TLS 1.2 and
+	// below; it has no effect on TLS 1.3, whose suites aren't
+	// user-configurable in crypto/tls. Nil means the Go defaults.
+	CipherSuites []uint16
+
+	// ALPN lists the application-layer protocols offered during the
+	// handshake, in preference order, e.g. []string{"h2", "http/1.1"}.
+	ALPN []string
+
+	// PreferServerCipherSuites is copied into the tls.Config built by
+	// tlsConfig. crypto/tls does not consult that field on client
+	// connections, so it has no effect on connections built from this
+	// profile.
+	PreferServerCipherSuites bool
+
+	// SessionResumption, when false, disables TLS session tickets. When
+	// true, tickets stay enabled, but tlsConfig sets no ClientSessionCache,
+	// so client connections built from this profile still do a full
+	// handshake every time and do not resume sessions.
+	SessionResumption bool
+}
+
+// weakCipherSuites are suites Validate rejects outright: RC4, 3DES, and
+// non-AEAD CBC suites are all considered known-weak.
+var weakCipherSuites = map[uint16]string{ // nolint[gochecknoglobals]
+	tls.TLS_RSA_WITH_RC4_128_SHA:             "RC4",
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA:        "3DES",
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA:         "CBC",
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA:         "CBC",
+	tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA:       "RC4",
+	tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA:     "RC4",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA:   "CBC",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA: "CBC",
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA:   "CBC",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA: "CBC",
+
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA:     "3DES",
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA256:         "CBC",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:   "CBC",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256: "CBC",
+}
+
+// DefaultTLSProfile returns a TLSProfile matching the client's behavior
+// before TLSProfile existed, so existing callers are unaffected unless they
+// opt into something stricter.
+func DefaultTLSProfile() TLSProfile {
+	return TLSProfile{
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: false,
+		SessionResumption:        true,
+	}
+}
+
+// Validate returns an error when the profile includes a known-weak cipher
+// suite, when MinVersion is set below TLS 1.2 (no longer acceptable for
+// talking to the API), or when MaxVersion is set below MinVersion.
+func (p TLSProfile) Validate() error {
+	for _, suite := range p.CipherSuites {
+		if reason, weak := weakCipherSuites[suite]; weak {
+			return errors.Errorf("TLS profile includes known-weak cipher suite %#x (%s)", suite, reason)
+		}
+	}
+
+	if p.MinVersion != 0 && p.MinVersion < tls.VersionTLS12 {
+		return errors.Errorf("TLS profile minimum version %#x is below TLS 1.2", p.MinVersion)
+	}
+
+	if p.MaxVersion != 0 && p.MinVersion != 0 && p.MaxVersion < p.MinVersion {
+		return errors.New("TLS profile maximum version is below its minimum version")
+	}
+
+	return nil
+}
+
+// tlsConfig builds a *tls.Config matching this profile.
+func (p TLSProfile) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:               p.MinVersion,
+		MaxVersion:               p.MaxVersion,
+		CipherSuites:             p.CipherSuites,
+		NextProtos:               p.ALPN,
+		PreferServerCipherSuites: p.PreferServerCipherSuites,
+		SessionTicketsDisabled:   !p.SessionResumption,
+	}
+}
+
+// ClientConfig collects the settings used to construct a pmapi client's
+// underlying *http.Client; NewTLSReporter takes one so TLSProfile governs
+// report delivery the same as it would any other connection. It is not yet
+// threaded into the API connections that PinVerifier validates — see the
+// TLSProfile doc comment.
+type ClientConfig struct {
+	// AppVersion is the client's app version. NewTLSReporter does not use
+	// it: each report's x-pm-appversion header carries that report's own
+	// AppVersion.
+	AppVersion string
+
+	// UserAgent is sent as the User-Agent header on every request.
+	UserAgent string
+
+	// TLSProfile controls the TLS parameters used for connections to the
+	// API. NewClientConfig sets it to DefaultTLSProfile; a zero-value
+	// ClientConfig has a zero-value TLSProfile.
+	TLSProfile TLSProfile
+}
+
+// NewClientConfig returns a ClientConfig with DefaultTLSProfile, so existing
+// callers that don't set TLSProfile explicitly see no change in behavior.
+func NewClientConfig(appVersion, userAgent string) ClientConfig {
+	return ClientConfig{
+		AppVersion: appVersion,
+		UserAgent:  userAgent,
+		TLSProfile: DefaultTLSProfile(),
+	}
+}
+
+// negotiated fills in the negotiated-version/negotiated-cipher/negotiated-alpn
+// fields of a TLSReport from the handshake state observed for a connection,
+// so pin-failure telemetry is diagnosable even when the failure turns out to
+// be TLS-configuration related rather than a genuine pin mismatch.
+func negotiated(report *TLSReport, state tls.ConnectionState) {
+	report.NegotiatedVersion = tlsVersionName(state.Version)
+	report.NegotiatedCipher = tls.CipherSuiteName(state.CipherSuite)
+	report.NegotiatedALPN = state.NegotiatedProtocol
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS1.0"
+	case tls.VersionTLS11:
+		return "TLS1.1"
+	case tls.VersionTLS12:
+		return "TLS1.2"
+	case tls.VersionTLS13:
+		return "TLS1.3"
+	default:
+		return "unknown"
+	}
+}
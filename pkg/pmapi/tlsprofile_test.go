@@ -0,0 +1,60 @@
+package pmapi
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestDefaultTLSProfileValidates(t *testing.T) {
+	if err := DefaultTLSProfile().Validate(); err != nil {
+		t.Fatalf("DefaultTLSProfile().Validate() error = %v, want nil", err)
+	}
+}
+
+func TestTLSProfileValidateRejectsWeakCipherSuite(t *testing.T) {
+	profile := DefaultTLSProfile()
+	profile.CipherSuites = []uint16{tls.TLS_RSA_WITH_RC4_128_SHA}
+
+	if err := profile.Validate(); err == nil {
+		t.Fatal("Validate() error = nil, want error for known-weak cipher suite")
+	}
+}
+
+func TestTLSProfileValidateRejectsBelowTLS12(t *testing.T) {
+	profile := DefaultTLSProfile()
+	profile.MinVersion = tls.VersionTLS11
+
+	if err := profile.Validate(); err == nil {
+		t.Fatal("Validate() error = nil, want error for MinVersion below TLS 1.2")
+	}
+}
+
+func TestTLSProfileValidateRejectsInvertedVersionRange(t *testing.T) {
+	profile := TLSProfile{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS12}
+
+	if err := profile.Validate(); err == nil {
+		t.Fatal("Validate() error = nil, want error for MaxVersion below MinVersion")
+	}
+}
+
+func TestTLSProfileValidateAcceptsAEADSuite(t *testing.T) {
+	profile := DefaultTLSProfile()
+	profile.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+
+	if err := profile.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v, want nil for AEAD cipher suite", err)
+	}
+}
+
+func TestNewClientConfigUsesDefaultTLSProfile(t *testing.T) {
+	cfg := NewClientConfig("bridge_1.0.0", "test-agent")
+	want := DefaultTLSProfile()
+
+	if cfg.AppVersion != "bridge_1.0.0" || cfg.UserAgent != "test-agent" {
+		t.Fatalf("NewClientConfig() = %+v, unexpected AppVersion/UserAgent", cfg)
+	}
+
+	if cfg.TLSProfile.MinVersion != want.MinVersion || cfg.TLSProfile.SessionResumption != want.SessionResumption {
+		t.Fatal("NewClientConfig() did not set DefaultTLSProfile")
+	}
+}
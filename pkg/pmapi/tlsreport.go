@@ -1,15 +1,10 @@
 package pmapi
 
 import (
-	"bytes"
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
 // ErrTLSMismatch indicates that no TLS fingerprint match could be found.
@@ -30,6 +25,12 @@ var TrustedAPIPins = []string{ // nolint[gochecknoglobals]
 // TLSReportURI is the address where TLS reports should be sent.
 const TLSReportURI = "https://reports.protonmail.ch/reports/tls"
 
+// TLSReportSecondaryURI is a secondary, out-of-band submission endpoint,
+// used as a fallback when delivery to TLSReportURI fails. TLSReporter pins
+// neither endpoint: both are checked only against the system's trusted
+// root certificates.
+const TLSReportSecondaryURI = "https://reports-secondary.protonmail.ch/reports/tls"
+
 // TLSReport is inspired by https://tools.ietf.org/html/rfc7469#section-3.
 // When a TLS key mismatch is detected, a TLSReport is posted to TLSReportURI.
 type TLSReport struct {
@@ -86,6 +87,28 @@ type TLSReport struct {
 
 	// AppVersion is used to set `x-pm-appversion` json format from datatheorem/TrustKit.
 	AppVersion string `json:"app-version"`
+
+	// ReportID identifies the report for correlation with its detached
+	// signature; it is derived from ReporterKey.
+	ReportID string `json:"report-id,omitempty"`
+
+	// ReporterKey is the base64-encoded Ed25519 public key fingerprint of
+	// the bridge install that produced this report. Paired with the
+	// x-pm-report-signature HTTP header, it lets the collector detect
+	// reports forged or mangled in transit.
+	ReporterKey string `json:"reporter-key,omitempty"`
+
+	// NegotiatedVersion is the TLS version negotiated for the connection
+	// that produced this report, e.g. "TLS1.3".
+	NegotiatedVersion string `json:"negotiated-version,omitempty"`
+
+	// NegotiatedCipher is the cipher suite negotiated for the connection
+	// that produced this report.
+	NegotiatedCipher string `json:"negotiated-cipher,omitempty"`
+
+	// NegotiatedALPN is the application-layer protocol negotiated for the
+	// connection that produced this report, e.g. "h2".
+	NegotiatedALPN string `json:"negotiated-alpn,omitempty"`
 }
 
 // NewTLSReport constructs a new TLSreport configured with the given app version and known pinned public keys.
@@ -107,39 +130,3 @@ func NewTLSReport(host, port, server string, certChain, knownPins []string, appV
 
 	return
 }
-
-// postCertIssueReport posts the given TLS report to the standard TLS Report URI.
-func postCertIssueReport(report TLSReport, userAgent string) {
-	b, err := json.Marshal(report)
-	if err != nil {
-		logrus.WithError(err).Error("Failed to marshal TLS report")
-		return
-	}
-
-	req, err := http.NewRequest("POST", TLSReportURI, bytes.NewReader(b))
-	if err != nil {
-		logrus.WithError(err).Error("Failed to create http request")
-		return
-	}
-
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Set("User-Agent", userAgent)
-	req.Header.Set("x-pm-apiversion", strconv.Itoa(Version))
-	req.Header.Set("x-pm-appversion", report.AppVersion)
-
-	logrus.WithField("request", req).Warn("Reporting TLS mismatch")
-	res, err := (&http.Client{}).Do(req)
-	if err != nil {
-		logrus.WithError(err).Error("Failed to report TLS mismatch")
-		return
-	}
-
-	logrus.WithField("response", res).Error("Reported TLS mismatch")
-
-	if res.StatusCode != http.StatusOK {
-		logrus.WithField("status", http.StatusOK).Error("StatusCode was not OK")
-	}
-
-	_, _ = ioutil.ReadAll(res.Body)
-	_ = res.Body.Close()
-}
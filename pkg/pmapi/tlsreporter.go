@@ -0,0 +1,484 @@
+package pmapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// tlsReportAggregationWindow is the interval over which duplicate TLS reports
+// for the same (hostname, port, notedHostname, appVersion) are collapsed into
+// a single envelope, mirroring the aggregation model of RFC 8460 SMTP TLS
+// reporting.
+const tlsReportAggregationWindow = time.Hour
+
+// tlsReportMaxAttempts is the number of delivery attempts made for a given
+// envelope before we stop retrying it on this run; it stays queued on disk,
+// marked exhausted, and is retried again on the next bridge start.
+const tlsReportMaxAttempts = 8
+
+// tlsReportRetryBaseDelay is the base delay used for the exponential backoff
+// between delivery attempts; jitter is added on top of it. It also sets how
+// often the delivery loop wakes up to retry whatever is still pending.
+const tlsReportRetryBaseDelay = 5 * time.Second
+
+// tlsReportRetryMaxDelay caps the exponential backoff so a single envelope
+// stuck on a dead host is never scheduled further out than this, however
+// high its attempt count climbs.
+const tlsReportRetryMaxDelay = 10 * time.Minute
+
+// tlsReportQueueDir is the name of the directory, relative to the reporter's
+// config dir, in which undelivered envelopes are persisted.
+const tlsReportQueueDir = "tls_reports"
+
+// tlsReportKey identifies the bucket that a TLSReport is aggregated into.
+type tlsReportKey struct {
+	Hostname      string
+	Port          int
+	NotedHostname string
+	AppVersion    string
+}
+
+// TLSReportEnvelope is the aggregated, on-the-wire form of one or more
+// TLSReports sharing the same tlsReportKey within a tlsReportAggregationWindow.
+type TLSReportEnvelope struct {
+	Report             TLSReport `json:"report"`
+	FailedSessionCount int       `json:"failed-session-count"`
+	DateRangeStart     string    `json:"date-range-start"`
+	DateRangeEnd       string    `json:"date-range-end"`
+
+	attempts int
+	// nextAttempt is when this envelope becomes eligible for another
+	// delivery attempt; deliverPending skips it on any tick before then,
+	// so one envelope's backoff can never block another's delivery.
+	nextAttempt time.Time
+	// exhausted is set once attempts reaches tlsReportMaxAttempts; the
+	// envelope stays queued on disk but is no longer retried until the
+	// next bridge start.
+	exhausted bool
+}
+
+// TLSReporter aggregates TLS pin-failure reports and delivers them to
+// TLSReportURI on a background goroutine, retrying transient failures with
+// exponential backoff and persisting undelivered envelopes across bridge
+// restarts.
+type TLSReporter struct {
+	locker sync.Mutex
+
+	queueDir  string
+	userAgent string
+	client    *http.Client
+	signer    *TLSReportSigner
+
+	// primaryURI and secondaryURI default to TLSReportURI and
+	// TLSReportSecondaryURI; tests point them at an httptest server
+	// instead of the real collector.
+	primaryURI   string
+	secondaryURI string
+
+	pending map[tlsReportKey]*TLSReportEnvelope
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	reportCh chan TLSReport
+	flushCh  chan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewTLSReporter creates a TLSReporter which persists its queue under
+// configDir and identifies itself to the report endpoint with cfg.UserAgent.
+// It dials out using cfg.TLSProfile, so a profile forcing TLS 1.3-only or
+// restricting cipher suites applies to report delivery exactly as it would
+// to any other pmapi connection. The returned reporter's background
+// goroutines must be stopped with Close.
+func NewTLSReporter(configDir string, cfg ClientConfig) (*TLSReporter, error) {
+	queueDir := filepath.Join(configDir, tlsReportQueueDir)
+	if err := os.MkdirAll(queueDir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create TLS report queue dir")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	reporter := &TLSReporter{
+		queueDir:  queueDir,
+		userAgent: cfg.UserAgent,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: cfg.TLSProfile.tlsConfig()},
+		},
+		pending:      make(map[tlsReportKey]*TLSReportEnvelope),
+		ctx:          ctx,
+		cancel:       cancel,
+		reportCh:     make(chan TLSReport, 64),
+		flushCh:      make(chan chan struct{}),
+		primaryURI:   TLSReportURI,
+		secondaryURI: TLSReportSecondaryURI,
+	}
+
+	reporter.loadQueuedEnvelopes()
+
+	reporter.wg.Add(2)
+	go reporter.dispatch()
+	go reporter.deliveryLoop()
+
+	return reporter, nil
+}
+
+// SetSigner attaches a TLSReportSigner so that every envelope delivered from
+// this point on carries a detached signature over the canonical-JSON form of
+// the whole envelope. It is safe
+// to call before the reporter has delivered anything; reports queued earlier
+// are signed too, since signing happens at delivery time.
+func (r *TLSReporter) SetSigner(signer *TLSReportSigner) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	r.signer = signer
+}
+
+// Report enqueues a TLSReport for aggregated, retryable delivery. It never
+// blocks on network I/O.
+func (r *TLSReporter) Report(report TLSReport) {
+	select {
+	case r.reportCh <- report:
+	case <-r.ctx.Done():
+	}
+}
+
+// Flush blocks until every currently-queued envelope has been attempted at
+// least once, or until ctx is done. It is intended to be called on bridge
+// shutdown so we don't silently drop evidence of an active MITM attempt.
+func (r *TLSReporter) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+
+	select {
+	case r.flushCh <- done:
+	case <-r.ctx.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the reporter's background goroutines, persisting any envelopes
+// that are still undelivered. It cancels any delivery attempt in flight
+// rather than waiting for it to finish on its own, so shutdown is bounded.
+func (r *TLSReporter) Close() {
+	r.cancel()
+	r.wg.Wait()
+	r.persistPending()
+}
+
+// dispatch only ever aggregates incoming reports; it never performs network
+// I/O, so it stays responsive to Report() even while a delivery attempt is
+// sleeping through a backoff or blocked on a slow POST in deliveryLoop.
+func (r *TLSReporter) dispatch() {
+	defer r.wg.Done()
+
+	for {
+		select {
+		case report := <-r.reportCh:
+			r.aggregate(report)
+
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliveryLoop is the only goroutine that performs network I/O. Keeping it
+// separate from dispatch means a long backoff sleep or a stalled POST never
+// blocks Report(), and canceling r.ctx (from Close) unblocks it immediately
+// instead of leaving bridge shutdown hanging on an in-flight attempt.
+func (r *TLSReporter) deliveryLoop() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(tlsReportRetryBaseDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case done := <-r.flushCh:
+			r.deliverPending()
+			close(done)
+
+		case <-ticker.C:
+			r.deliverPending()
+
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *TLSReporter) aggregate(report TLSReport) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	key := tlsReportKey{
+		Hostname:      report.Hostname,
+		Port:          report.Port,
+		NotedHostname: report.NotedHostname,
+		AppVersion:    report.AppVersion,
+	}
+
+	now := time.Now().Format(time.RFC3339)
+
+	envelope, ok := r.pending[key]
+	if !ok || time.Since(mustParseRFC3339(envelope.DateRangeStart)) > tlsReportAggregationWindow {
+		r.pending[key] = &TLSReportEnvelope{
+			Report:             report,
+			FailedSessionCount: 1,
+			DateRangeStart:     now,
+			DateRangeEnd:       now,
+		}
+		return
+	}
+
+	envelope.FailedSessionCount++
+	envelope.DateRangeEnd = now
+}
+
+func (r *TLSReporter) deliverPending() {
+	r.locker.Lock()
+	envelopes := make(map[tlsReportKey]*TLSReportEnvelope, len(r.pending))
+	for key, envelope := range r.pending {
+		envelopes[key] = envelope
+	}
+	r.locker.Unlock()
+
+	now := time.Now()
+
+	for key, envelope := range envelopes {
+		if r.ctx.Err() != nil {
+			return
+		}
+
+		// Skip envelopes that are exhausted or still backing off instead
+		// of blocking on them: a single dead host must never delay
+		// delivery of every other host's queued report.
+		if envelope.exhausted || now.Before(envelope.nextAttempt) {
+			continue
+		}
+
+		if err := r.deliver(envelope); err != nil {
+			envelope.attempts++
+
+			logrus.WithError(err).
+				WithField("attempts", envelope.attempts).
+				Warn("Failed to deliver TLS report")
+
+			if envelope.attempts >= tlsReportMaxAttempts {
+				envelope.exhausted = true
+				logrus.WithField("key", key).Error("Giving up on TLS report until bridge restart; it stays queued on disk")
+			} else {
+				backoff := tlsReportRetryBaseDelay * time.Duration(1<<uint(envelope.attempts-1))
+				if backoff > tlsReportRetryMaxDelay {
+					backoff = tlsReportRetryMaxDelay
+				}
+				jitter := time.Duration(rand.Int63n(int64(tlsReportRetryBaseDelay)))
+				envelope.nextAttempt = now.Add(backoff + jitter)
+			}
+
+			// Persist on every failed attempt, not only at graceful
+			// shutdown: a crash or kill -9 must not silently drop evidence
+			// of an active MITM attempt.
+			r.persistEnvelope(key, envelope)
+
+			continue
+		}
+
+		r.locker.Lock()
+		delete(r.pending, key)
+		r.locker.Unlock()
+
+		_ = os.Remove(r.envelopePath(key))
+	}
+}
+
+// deliver gzip-compresses and POSTs a single aggregated envelope. The caller
+// (deliverPending) is responsible for not calling this again for an envelope
+// until its backoff has elapsed. If the signer is set, it signs the whole
+// envelope (aggregation metadata included) and attaches the signature so the
+// collector can detect forged or mangled submissions. Delivery to
+// TLSReportURI is tried first; on failure the same body is posted to
+// TLSReportSecondaryURI. Neither endpoint is pinned; r.client checks both
+// only against the system's trusted roots. The request is bound to r.ctx, so it's
+// canceled promptly if Close is called mid-flight.
+func (r *TLSReporter) deliver(envelope *TLSReportEnvelope) error {
+	var signature string
+	if r.signer != nil {
+		envelope.Report.ReporterKey = r.signer.KeyFingerprint()
+		envelope.Report.ReportID = envelope.Report.ReporterKey
+
+		sig, err := r.signer.Sign(*envelope)
+		if err != nil {
+			return errors.Wrap(err, "failed to sign TLS report envelope")
+		}
+		signature = sig
+	}
+
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal TLS report envelope")
+	}
+
+	var gzipped bytes.Buffer
+	gzw := gzip.NewWriter(&gzipped)
+	if _, err := gzw.Write(b); err != nil {
+		return errors.Wrap(err, "failed to gzip TLS report envelope")
+	}
+	if err := gzw.Close(); err != nil {
+		return errors.Wrap(err, "failed to close gzip writer")
+	}
+
+	err = r.post(r.primaryURI, gzipped.Bytes(), envelope.Report.AppVersion, signature)
+	if err == nil {
+		return nil
+	}
+
+	logrus.WithError(err).Warn("Failed to deliver TLS report to primary endpoint; trying secondary")
+
+	return r.post(r.secondaryURI, gzipped.Bytes(), envelope.Report.AppVersion, signature)
+}
+
+// post sends a single gzip-compressed envelope to uri, attaching signature
+// in the TLSReportSignatureHeader when non-empty. The request is bound to
+// r.ctx so Close can abort it immediately instead of waiting it out.
+func (r *TLSReporter) post(uri string, body []byte, appVersion, signature string) error {
+	req, err := http.NewRequestWithContext(r.ctx, "POST", uri, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create http request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("User-Agent", r.userAgent)
+	req.Header.Set("x-pm-apiversion", strconv.Itoa(Version))
+	req.Header.Set("x-pm-appversion", appVersion)
+	if signature != "" {
+		req.Header.Set(TLSReportSignatureHeader, signature)
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to post TLS report")
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	_, _ = ioutil.ReadAll(res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("TLS report endpoint returned status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// persistEnvelope writes a single envelope to disk so it survives a crash,
+// not only a graceful Close.
+func (r *TLSReporter) persistEnvelope(key tlsReportKey, envelope *TLSReportEnvelope) {
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal TLS report envelope for persistence")
+		return
+	}
+
+	if err := ioutil.WriteFile(r.envelopePath(key), b, 0600); err != nil {
+		logrus.WithError(err).Error("Failed to persist TLS report envelope")
+	}
+}
+
+// persistPending writes every currently-pending envelope to disk. Called on
+// Close to also cover envelopes that were aggregated but never yet attempted
+// a delivery (and so never hit persistEnvelope).
+func (r *TLSReporter) persistPending() {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	for key, envelope := range r.pending {
+		r.persistEnvelope(key, envelope)
+	}
+}
+
+// loadQueuedEnvelopes restores envelopes that were persisted by a previous
+// run of the bridge.
+func (r *TLSReporter) loadQueuedEnvelopes() {
+	entries, err := ioutil.ReadDir(r.queueDir)
+	if err != nil {
+		return
+	}
+
+	// Deterministic load order makes behaviour reproducible in tests.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		b, err := ioutil.ReadFile(filepath.Join(r.queueDir, entry.Name()))
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to read queued TLS report envelope")
+			continue
+		}
+
+		var envelope TLSReportEnvelope
+		if err := json.Unmarshal(b, &envelope); err != nil {
+			logrus.WithError(err).Warn("Failed to unmarshal queued TLS report envelope")
+			continue
+		}
+
+		key := tlsReportKey{
+			Hostname:      envelope.Report.Hostname,
+			Port:          envelope.Report.Port,
+			NotedHostname: envelope.Report.NotedHostname,
+			AppVersion:    envelope.Report.AppVersion,
+		}
+
+		r.pending[key] = &envelope
+	}
+}
+
+func (r *TLSReporter) envelopePath(key tlsReportKey) string {
+	name := strconv.Itoa(int(fnvHash(key))) + ".json"
+	return filepath.Join(r.queueDir, name)
+}
+
+// fnvHash gives a stable, filesystem-safe name for a tlsReportKey without
+// pulling in a full hashing package for a handful of bytes.
+func fnvHash(key tlsReportKey) uint32 {
+	h := uint32(2166136261)
+	for _, s := range []string{key.Hostname, strconv.Itoa(key.Port), key.NotedHostname, key.AppVersion} {
+		for i := 0; i < len(s); i++ {
+			h ^= uint32(s[i])
+			h *= 16777619
+		}
+	}
+	return h
+}
+
+func mustParseRFC3339(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
@@ -0,0 +1,277 @@
+package pmapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestReporter(t *testing.T, primaryURI string) *TLSReporter {
+	t.Helper()
+
+	reporter, err := NewTLSReporter(t.TempDir(), NewClientConfig("bridge_1.0.0", "test-agent"))
+	if err != nil {
+		t.Fatalf("NewTLSReporter() error = %v", err)
+	}
+	t.Cleanup(reporter.Close)
+
+	if primaryURI != "" {
+		reporter.primaryURI = primaryURI
+		reporter.secondaryURI = primaryURI
+	}
+
+	return reporter
+}
+
+func testReport(host string) TLSReport {
+	return TLSReport{
+		Hostname:   host,
+		Port:       443,
+		AppVersion: "bridge_1.0.0",
+	}
+}
+
+// waitForPendingCount polls reporter.pending until it reaches want or timeout
+// elapses. Report() only enqueues onto reportCh; aggregation into r.pending
+// happens asynchronously on the dispatch() goroutine, so callers that just
+// called Report() can't assert on len(reporter.pending) without this.
+func waitForPendingCount(t *testing.T, reporter *TLSReporter, want int, timeout time.Duration) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		reporter.locker.Lock()
+		got := len(reporter.pending)
+		reporter.locker.Unlock()
+
+		if got == want {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("len(reporter.pending) = %d, want %d after waiting %s", got, want, timeout)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTLSReporterAggregatesDuplicateReports(t *testing.T) {
+	reporter := newTestReporter(t, "")
+
+	reporter.aggregate(testReport("api.protonmail.ch"))
+	reporter.aggregate(testReport("api.protonmail.ch"))
+	reporter.aggregate(testReport("api.protonmail.ch"))
+
+	if got := len(reporter.pending); got != 1 {
+		t.Fatalf("len(pending) = %d, want 1", got)
+	}
+
+	for _, envelope := range reporter.pending {
+		if envelope.FailedSessionCount != 3 {
+			t.Fatalf("FailedSessionCount = %d, want 3", envelope.FailedSessionCount)
+		}
+	}
+}
+
+func TestTLSReporterAggregationWindowRollover(t *testing.T) {
+	reporter := newTestReporter(t, "")
+
+	reporter.aggregate(testReport("api.protonmail.ch"))
+
+	var key tlsReportKey
+	for k, envelope := range reporter.pending {
+		key = k
+		envelope.DateRangeStart = time.Now().Add(-2 * tlsReportAggregationWindow).Format(time.RFC3339)
+	}
+
+	reporter.aggregate(testReport("api.protonmail.ch"))
+
+	envelope := reporter.pending[key]
+	if envelope.FailedSessionCount != 1 {
+		t.Fatalf("FailedSessionCount after window rollover = %d, want 1 (fresh envelope)", envelope.FailedSessionCount)
+	}
+}
+
+func TestTLSReporterPersistsEnvelopeOnFailedDelivery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	reporter := newTestReporter(t, srv.URL)
+
+	reporter.aggregate(testReport("api.protonmail.ch"))
+	reporter.deliverPending()
+
+	entries, err := os.ReadDir(reporter.queueDir)
+	if err != nil {
+		t.Fatalf("ReadDir(queueDir) error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("len(queueDir entries) = %d, want 1 envelope persisted after failed delivery", len(entries))
+	}
+}
+
+func TestTLSReporterDeliversAndClearsOnSuccess(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	reporter := newTestReporter(t, srv.URL)
+
+	reporter.aggregate(testReport("api.protonmail.ch"))
+	reporter.deliverPending()
+
+	if got := len(reporter.pending); got != 0 {
+		t.Fatalf("len(pending) after successful delivery = %d, want 0", got)
+	}
+
+	entries, _ := os.ReadDir(reporter.queueDir)
+	if len(entries) != 0 {
+		t.Fatalf("len(queueDir entries) after successful delivery = %d, want 0", len(entries))
+	}
+}
+
+func TestTLSReporterCloseDoesNotHangOnInFlightRequest(t *testing.T) {
+	// The handler just sleeps well past the test's own deadline; we tear
+	// the server down with CloseClientConnections (not Close, which waits
+	// for the in-flight handler to return) once the assertions are done.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Second)
+	}))
+	defer srv.CloseClientConnections()
+
+	reporter := newTestReporter(t, srv.URL)
+
+	reporter.aggregate(testReport("api.protonmail.ch"))
+
+	done := make(chan struct{})
+	go func() {
+		reporter.deliverPending()
+		close(done)
+	}()
+
+	// Give deliverPending a moment to enter the (now-blocked) POST before
+	// we cancel; Close should abort it via r.ctx rather than waiting for
+	// the server to respond.
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		reporter.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close() did not return promptly while a delivery was in flight")
+	}
+
+	<-done
+}
+
+func TestTLSReporterSkipsEnvelopeStillBackingOff(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	reporter := newTestReporter(t, srv.URL)
+
+	reporter.aggregate(testReport("api.protonmail.ch"))
+	reporter.deliverPending()
+
+	// One failed attempt hits both the primary and the secondary endpoint
+	// (which here is the same test server), so it accounts for 2 requests.
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("requests after first deliverPending = %d, want 2", got)
+	}
+
+	// The failed attempt above scheduled a future nextAttempt; a second
+	// tick right away must not retry it yet.
+	reporter.deliverPending()
+
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("requests after second deliverPending = %d, want 2 (still backing off)", got)
+	}
+}
+
+func TestTLSReporterStopsRetryingAfterMaxAttempts(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	reporter := newTestReporter(t, srv.URL)
+
+	reporter.locker.Lock()
+	key := tlsReportKey{Hostname: "api.protonmail.ch", Port: 443}
+	reporter.pending[key] = &TLSReportEnvelope{
+		Report:   testReport("api.protonmail.ch"),
+		attempts: tlsReportMaxAttempts - 1,
+	}
+	reporter.locker.Unlock()
+
+	reporter.deliverPending()
+
+	// One attempt hits both the primary and the secondary endpoint (the
+	// same test server here), so it accounts for 2 requests.
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("requests after exhausting attempts = %d, want 2", got)
+	}
+
+	reporter.locker.Lock()
+	exhausted := reporter.pending[key].exhausted
+	reporter.locker.Unlock()
+
+	if !exhausted {
+		t.Fatal("envelope not marked exhausted after reaching tlsReportMaxAttempts")
+	}
+
+	// A bridge-restart envelope that's exhausted must not be retried again
+	// on this run, even once its nextAttempt would otherwise have arrived.
+	reporter.deliverPending()
+
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("requests after further deliverPending on exhausted envelope = %d, want 2", got)
+	}
+}
+
+func TestTLSReporterEnvelopePersistenceRoundTrip(t *testing.T) {
+	reporter := newTestReporter(t, "")
+
+	key := tlsReportKey{Hostname: "api.protonmail.ch", Port: 443, AppVersion: "bridge_1.0.0"}
+	envelope := &TLSReportEnvelope{
+		Report:             testReport("api.protonmail.ch"),
+		FailedSessionCount: 2,
+		DateRangeStart:     time.Now().Format(time.RFC3339),
+		DateRangeEnd:       time.Now().Format(time.RFC3339),
+	}
+
+	reporter.persistEnvelope(key, envelope)
+
+	if _, err := os.Stat(filepath.Join(reporter.queueDir, filepath.Base(reporter.envelopePath(key)))); err != nil {
+		t.Fatalf("persisted envelope file missing: %v", err)
+	}
+
+	reloaded, err := NewTLSReporter(reporter.queueDir[:len(reporter.queueDir)-len(tlsReportQueueDir)-1], NewClientConfig("bridge_1.0.0", "test-agent"))
+	if err != nil {
+		t.Fatalf("NewTLSReporter() error = %v", err)
+	}
+	defer reloaded.Close()
+
+	if got := len(reloaded.pending); got != 1 {
+		t.Fatalf("len(pending) after reload = %d, want 1", got)
+	}
+}
@@ -0,0 +1,156 @@
+package pmapi
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// TLSReportSignatureHeader is the HTTP header carrying the detached Ed25519
+// signature over the canonical-JSON serialization of the transmitted
+// TLSReportEnvelope.
+const TLSReportSignatureHeader = "x-pm-report-signature"
+
+// ErrTLSReportSignatureMismatch indicates that a TLS report envelope's
+// signature doesn't match its body under the claimed reporter key.
+var ErrTLSReportSignatureMismatch = errors.New("TLS report signature does not match")
+
+// reporterKeyKeychainLabel is the label under which the reporter's long-lived
+// Ed25519 keypair is stored in the OS keychain.
+const reporterKeyKeychainLabel = "bridge-tls-reporter-key"
+
+// KeyStorer persists and retrieves opaque secret material keyed by label. The
+// bridge's OS-keychain-backed implementation satisfies this; TLSReportSigner
+// doesn't assume anything about the underlying storage.
+type KeyStorer interface {
+	Get(label string) ([]byte, error)
+	Set(label string, secret []byte) error
+}
+
+// TLSReportSigner attaches a detached Ed25519 signature to every outgoing
+// TLS report envelope so that reports.protonmail.ch (and our own tooling)
+// can detect reports forged or mangled by an attacker sitting on the very
+// TLS channel that just failed pin validation.
+type TLSReportSigner struct {
+	store      KeyStorer
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+// NewTLSReportSigner loads the bridge install's long-lived reporter keypair
+// from store, generating and persisting one if none exists yet.
+func NewTLSReportSigner(store KeyStorer) (*TLSReportSigner, error) {
+	signer := &TLSReportSigner{store: store}
+
+	if err := signer.loadOrGenerateKey(); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+func (s *TLSReportSigner) loadOrGenerateKey() error {
+	raw, err := s.store.Get(reporterKeyKeychainLabel)
+	if err == nil && len(raw) == ed25519.PrivateKeySize {
+		s.privateKey = ed25519.PrivateKey(raw)
+		s.publicKey = s.privateKey.Public().(ed25519.PublicKey)
+		return nil
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return errors.Wrap(err, "failed to generate TLS reporter keypair")
+	}
+
+	if err := s.store.Set(reporterKeyKeychainLabel, priv); err != nil {
+		return errors.Wrap(err, "failed to persist TLS reporter keypair")
+	}
+
+	s.privateKey = priv
+	s.publicKey = pub
+
+	return nil
+}
+
+// KeyFingerprint returns the base64-encoded public key of this bridge
+// install's reporter keypair, suitable for the report-id/reporter-key field.
+func (s *TLSReportSigner) KeyFingerprint() string {
+	return base64.StdEncoding.EncodeToString(s.publicKey)
+}
+
+// Sign returns the base64-encoded detached Ed25519 signature over the
+// canonical-JSON serialization of v, to be sent in the
+// TLSReportSignatureHeader alongside the transmitted body. v should be
+// whatever is actually put on the wire (a TLSReportEnvelope), not just the
+// nested TLSReport, so that an on-path attacker can't rewrite aggregation
+// metadata without invalidating the signature.
+func (s *TLSReportSigner) Sign(v interface{}) (string, error) {
+	canonical, err := canonicalJSON(v)
+	if err != nil {
+		return "", err
+	}
+
+	sig := ed25519.Sign(s.privateKey, canonical)
+
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// VerifyTLSReport checks that sig is a valid base64-encoded Ed25519
+// signature over the canonical-JSON serialization of envelope, under the
+// base64-encoded public key pubkey. Server-side tooling and tests use this to
+// validate a delivered TLSReportEnvelope without depending on the rest of
+// the bridge.
+func VerifyTLSReport(envelope TLSReportEnvelope, sig, pubkey string) error {
+	rawSig, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode TLS report signature")
+	}
+
+	rawKey, err := base64.StdEncoding.DecodeString(pubkey)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode TLS reporter public key")
+	}
+
+	if len(rawKey) != ed25519.PublicKeySize {
+		return errors.New("invalid TLS reporter public key size")
+	}
+
+	canonical, err := canonicalJSON(envelope)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(rawKey), canonical, rawSig) {
+		return ErrTLSReportSignatureMismatch
+	}
+
+	return nil
+}
+
+// canonicalJSON serializes v as JSON with object keys sorted
+// lexicographically and no extraneous whitespace, so that sender and
+// verifier always sign/verify identical bytes regardless of the Go struct
+// field declaration order used on either end (encoding/json.Marshal only
+// sorts map keys, not struct fields). v is round-tripped through a generic
+// map so the final Marshal sees maps all the way down.
+func canonicalJSON(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal value for signing")
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, errors.Wrap(err, "failed to decode value into canonical form")
+	}
+
+	canonical, err := json.Marshal(generic)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal canonical form")
+	}
+
+	return canonical, nil
+}
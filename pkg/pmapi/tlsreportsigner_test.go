@@ -0,0 +1,143 @@
+package pmapi
+
+import (
+	"errors"
+	"testing"
+)
+
+type memKeyStore struct {
+	secrets map[string][]byte
+}
+
+func newMemKeyStore() *memKeyStore {
+	return &memKeyStore{secrets: make(map[string][]byte)}
+}
+
+func (m *memKeyStore) Get(label string) ([]byte, error) {
+	secret, ok := m.secrets[label]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return secret, nil
+}
+
+func (m *memKeyStore) Set(label string, secret []byte) error {
+	m.secrets[label] = secret
+	return nil
+}
+
+func testEnvelope() TLSReportEnvelope {
+	return TLSReportEnvelope{
+		Report:             testReport("api.protonmail.ch"),
+		FailedSessionCount: 3,
+		DateRangeStart:     "2026-07-26T00:00:00Z",
+		DateRangeEnd:       "2026-07-26T01:00:00Z",
+	}
+}
+
+func TestTLSReportSignerSignVerifyRoundTrip(t *testing.T) {
+	signer, err := NewTLSReportSigner(newMemKeyStore())
+	if err != nil {
+		t.Fatalf("NewTLSReportSigner() error = %v", err)
+	}
+
+	envelope := testEnvelope()
+
+	sig, err := signer.Sign(envelope)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	if err := VerifyTLSReport(envelope, sig, signer.KeyFingerprint()); err != nil {
+		t.Fatalf("VerifyTLSReport() error = %v, want nil", err)
+	}
+}
+
+func TestTLSReportSignerRejectsTamperedAggregationMetadata(t *testing.T) {
+	signer, err := NewTLSReportSigner(newMemKeyStore())
+	if err != nil {
+		t.Fatalf("NewTLSReportSigner() error = %v", err)
+	}
+
+	envelope := testEnvelope()
+
+	sig, err := signer.Sign(envelope)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	// An attacker rewrites the aggregation metadata without touching the
+	// nested report; this must invalidate the signature.
+	tampered := envelope
+	tampered.FailedSessionCount = 999
+
+	if err := VerifyTLSReport(tampered, sig, signer.KeyFingerprint()); err != ErrTLSReportSignatureMismatch {
+		t.Fatalf("VerifyTLSReport() on tampered envelope error = %v, want ErrTLSReportSignatureMismatch", err)
+	}
+}
+
+func TestTLSReportSignerRejectsWrongKey(t *testing.T) {
+	signer, err := NewTLSReportSigner(newMemKeyStore())
+	if err != nil {
+		t.Fatalf("NewTLSReportSigner() error = %v", err)
+	}
+
+	otherSigner, err := NewTLSReportSigner(newMemKeyStore())
+	if err != nil {
+		t.Fatalf("NewTLSReportSigner() error = %v", err)
+	}
+
+	envelope := testEnvelope()
+
+	sig, err := signer.Sign(envelope)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	if err := VerifyTLSReport(envelope, sig, otherSigner.KeyFingerprint()); err != ErrTLSReportSignatureMismatch {
+		t.Fatalf("VerifyTLSReport() with wrong key error = %v, want ErrTLSReportSignatureMismatch", err)
+	}
+}
+
+func TestTLSReportSignerPersistsKeyAcrossInstances(t *testing.T) {
+	store := newMemKeyStore()
+
+	first, err := NewTLSReportSigner(store)
+	if err != nil {
+		t.Fatalf("NewTLSReportSigner() error = %v", err)
+	}
+
+	second, err := NewTLSReportSigner(store)
+	if err != nil {
+		t.Fatalf("NewTLSReportSigner() error = %v", err)
+	}
+
+	if first.KeyFingerprint() != second.KeyFingerprint() {
+		t.Fatal("expected the same reporter keypair to be reloaded from the key store")
+	}
+}
+
+func TestCanonicalJSONSortsKeysRegardlessOfStructFieldOrder(t *testing.T) {
+	type fieldsAB struct {
+		A string `json:"a"`
+		B string `json:"b"`
+	}
+	type fieldsBA struct {
+		B string `json:"b"`
+		A string `json:"a"`
+	}
+
+	ab, err := canonicalJSON(fieldsAB{A: "1", B: "2"})
+	if err != nil {
+		t.Fatalf("canonicalJSON() error = %v", err)
+	}
+
+	ba, err := canonicalJSON(fieldsBA{A: "1", B: "2"})
+	if err != nil {
+		t.Fatalf("canonicalJSON() error = %v", err)
+	}
+
+	if string(ab) != string(ba) {
+		t.Fatalf("canonicalJSON() depends on struct field order: %q != %q", ab, ba)
+	}
+}